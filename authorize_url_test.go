@@ -0,0 +1,75 @@
+package soapforce
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"testing"
+)
+
+func TestBuildAuthorizeURL(t *testing.T) {
+	c := &Client{LoginUrl: "test.salesforce.com", ClientID: "clientId123"}
+	authURL, codeVerifier, state, err := c.BuildAuthorizeURL("https://example.com/callback", []string{"api", "refresh_token"})
+	if err != nil {
+		t.Fatalf("BuildAuthorizeURL() error = %v", err)
+	}
+
+	if decoded, err := base64.RawURLEncoding.DecodeString(codeVerifier); err != nil || len(decoded) != 32 {
+		t.Errorf("codeVerifier = %q (decoded len %d, err %v), want 32 raw bytes", codeVerifier, len(decoded), err)
+	}
+	if decoded, err := base64.RawURLEncoding.DecodeString(state); err != nil || len(decoded) != 16 {
+		t.Errorf("state = %q (decoded len %d, err %v), want 16 raw bytes", state, len(decoded), err)
+	}
+
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("url.Parse(authURL) error = %v", err)
+	}
+	if got, want := parsed.Scheme+"://"+parsed.Host+parsed.Path, "https://test.salesforce.com/services/oauth2/authorize"; got != want {
+		t.Errorf("authURL base = %q, want %q", got, want)
+	}
+
+	q := parsed.Query()
+	if got := q.Get("response_type"); got != "code" {
+		t.Errorf("response_type = %q, want %q", got, "code")
+	}
+	if got := q.Get("client_id"); got != "clientId123" {
+		t.Errorf("client_id = %q, want %q", got, "clientId123")
+	}
+	if got := q.Get("redirect_uri"); got != "https://example.com/callback" {
+		t.Errorf("redirect_uri = %q, want %q", got, "https://example.com/callback")
+	}
+	if got := q.Get("scope"); got != "api refresh_token" {
+		t.Errorf("scope = %q, want %q", got, "api refresh_token")
+	}
+	if got := q.Get("state"); got != state {
+		t.Errorf("state query param = %q, want %q", got, state)
+	}
+	if got := q.Get("code_challenge_method"); got != "S256" {
+		t.Errorf("code_challenge_method = %q, want %q", got, "S256")
+	}
+
+	sum := sha256.Sum256([]byte(codeVerifier))
+	wantChallenge := base64.RawURLEncoding.EncodeToString(sum[:])
+	if got := q.Get("code_challenge"); got != wantChallenge {
+		t.Errorf("code_challenge = %q, want %q (sha256(code_verifier))", got, wantChallenge)
+	}
+}
+
+func TestBuildAuthorizeURLUnique(t *testing.T) {
+	c := &Client{LoginUrl: "test.salesforce.com", ClientID: "clientId123"}
+	_, verifier1, state1, err := c.BuildAuthorizeURL("https://example.com/callback", nil)
+	if err != nil {
+		t.Fatalf("BuildAuthorizeURL() error = %v", err)
+	}
+	_, verifier2, state2, err := c.BuildAuthorizeURL("https://example.com/callback", nil)
+	if err != nil {
+		t.Fatalf("BuildAuthorizeURL() error = %v", err)
+	}
+	if verifier1 == verifier2 {
+		t.Error("BuildAuthorizeURL() produced the same codeVerifier twice")
+	}
+	if state1 == state2 {
+		t.Error("BuildAuthorizeURL() produced the same state twice")
+	}
+}
@@ -1,12 +1,20 @@
 package soapforce
 
 import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 )
 
 const (
@@ -23,18 +31,22 @@ type Client struct {
 	LoginUrl        string
 	ClientID        string
 	ClientSecret    string
-	ServerUrl		string
+	ServerUrl       string
 
 	soapClient      *Soap
 	credentials     *Login
+	autoRefresh     bool
+	retryPolicy     RetryPolicy
 }
 
 func NewClient() *Client {
 	soap := NewSoap("", true, nil)
 	return &Client{
-		soapClient: soap,
-		ApiVersion: DefaultApiVersion,
-		LoginUrl:   DefaultLoginUrl,
+		soapClient:  soap,
+		ApiVersion:  DefaultApiVersion,
+		LoginUrl:    DefaultLoginUrl,
+		autoRefresh: true,
+		retryPolicy: NewDefaultRetryPolicy(),
 	}
 }
 
@@ -78,9 +90,111 @@ func (c *Client) GetSessionID() string {
 	return c.SessionId
 }
 
-func (c *Client) RefreshSessionID() (err error) {
+// SetAutoRefresh controls whether a SOAP call that fails with an
+// INVALID_SESSION_ID fault is transparently retried once after refreshing
+// the session via RefreshSessionID. It is enabled by default.
+func (c *Client) SetAutoRefresh(enabled bool) {
+	c.autoRefresh = enabled
+}
+
+// withSessionRefresh runs fn and, if it returns an INVALID_SESSION_ID fault,
+// auto-refresh is enabled, and credentials are available to re-authenticate
+// with, refreshes the session once and retries fn.
+func (c *Client) withSessionRefresh(ctx context.Context, fn func() error) error {
+	err := fn()
+	if err == nil || !c.autoRefresh || c.credentials == nil || !isInvalidSessionFault(err) {
+		return err
+	}
+	if refreshErr := c.RefreshSessionIDContext(ctx); refreshErr != nil {
+		return err
+	}
+	return fn()
+}
+
+// isInvalidSessionFault reports whether err represents a Salesforce
+// INVALID_SESSION_ID SOAP fault.
+func isInvalidSessionFault(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "INVALID_SESSION_ID")
+}
+
+// callCtx runs fn, a blocking SOAP or OAuth call, honoring ctx cancellation
+// and deadlines. The underlying soap client has no native context support,
+// so fn is run on a goroutine and raced against ctx.Done(); if ctx is done
+// first, ctx.Err() is returned and fn is left to finish in the background.
+func callCtx(ctx context.Context, fn func() error) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-done:
+		return err
+	}
+}
+
+// SetRetryPolicy installs the RetryPolicy used to throttle calls in
+// response to LimitInfoHeader and to retry transient SOAP faults. Passing
+// nil disables both throttling and retries.
+func (c *Client) SetRetryPolicy(policy RetryPolicy) {
+	c.retryPolicy = policy
+}
+
+// callWithRetry runs fn, honoring ctx cancellation, and consults
+// c.retryPolicy to throttle ahead of the call based on the most recently
+// observed LimitInfoHeader and to retry fn with backoff when it fails with
+// a transient SOAP fault.
+func (c *Client) callWithRetry(ctx context.Context, fn func() error) error {
+	if c.retryPolicy == nil {
+		return callCtx(ctx, fn)
+	}
+
+	attempt := 1
+	for {
+		c.retryPolicy.Throttle(ctx, c.soapClient.GetInfo())
+		err := callCtx(ctx, fn)
+		if err == nil {
+			return nil
+		}
+		retry, delay := c.retryPolicy.ShouldRetry(attempt, err)
+		if !retry {
+			return err
+		}
+		if sleepErr := sleepCtx(ctx, delay); sleepErr != nil {
+			return err
+		}
+		attempt++
+	}
+}
+
+// sleepCtx sleeps for d, returning early with ctx.Err() if ctx is done
+// first.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (c *Client) RefreshSessionIDContext(ctx context.Context) (err error) {
 	var res *LoginResponse
-	if res, err = c.soapClient.Login(c.credentials); err != nil {
+	err = c.callWithRetry(ctx, func() error {
+		var err error
+		res, err = c.soapClient.Login(c.credentials)
+		return err
+	})
+	if err != nil {
 		return
 	}
 
@@ -95,12 +209,21 @@ func (c *Client) RefreshSessionID() (err error) {
 	return
 }
 
-func (c *Client) Login(u string, p string) (*LoginResult, error) {
+func (c *Client) RefreshSessionID() error {
+	return c.RefreshSessionIDContext(context.Background())
+}
+
+func (c *Client) LoginContext(ctx context.Context, u string, p string) (*LoginResult, error) {
 	c.credentials = &Login{
 		Username: u,
 		Password: p,
 	}
-	res, err := c.soapClient.Login(c.credentials)
+	var res *LoginResponse
+	err := c.callWithRetry(ctx, func() error {
+		var err error
+		res, err = c.soapClient.Login(c.credentials)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -110,6 +233,10 @@ func (c *Client) Login(u string, p string) (*LoginResult, error) {
 	return res.Result, nil
 }
 
+func (c *Client) Login(u string, p string) (*LoginResult, error) {
+	return c.LoginContext(context.Background(), u, p)
+}
+
 func (c *Client) SetClientId(ClientID string) {
 	c.ClientID = ClientID
 }
@@ -118,14 +245,24 @@ func (c *Client) SetClientSecret(ClientSecret string) {
 	c.ClientSecret = ClientSecret
 }
 
-func (c *Client) LoginWithOAuth(username, password string) error {
+// postForm POSTs params to url, honoring ctx cancellation and deadlines.
+func postForm(ctx context.Context, url string, params url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return http.DefaultClient.Do(req)
+}
+
+func (c *Client) LoginWithOAuthContext(ctx context.Context, username, password string) error {
 	params := url.Values{}
 	params.Add("grant_type", "password")
 	params.Add("client_id", c.ClientID)
 	params.Add("client_secret", c.ClientSecret)
 	params.Add("username", username)
 	params.Add("password", password)
-	resp, err := http.PostForm(fmt.Sprintf("https://%s/services/oauth2/token", c.LoginUrl), params)
+	resp, err := postForm(ctx, fmt.Sprintf("https://%s/services/oauth2/token", c.LoginUrl), params)
 	if err != nil {
 		return err
 	}
@@ -139,19 +276,26 @@ func (c *Client) LoginWithOAuth(username, password string) error {
 	if err != nil {
 		return err
 	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("soapforce: oauth password login failed: %s: %s", tokenResponse["error"], tokenResponse["error_description"])
+	}
 
 	c.soapClient.SetServerUrl(fmt.Sprintf("%s/services/Soap/u/%s", tokenResponse["instance_url"], c.ApiVersion))
 	c.SetAccessToken(tokenResponse["access_token"])
 	return nil
 }
 
-func (c *Client) Refresh(refreshToken string) error {
+func (c *Client) LoginWithOAuth(username, password string) error {
+	return c.LoginWithOAuthContext(context.Background(), username, password)
+}
+
+func (c *Client) RefreshContext(ctx context.Context, refreshToken string) error {
 	params := url.Values{}
 	params.Add("grant_type", "refresh_token")
 	params.Add("client_id", c.ClientID)
 	params.Add("client_secret", c.ClientSecret)
 	params.Add("refresh_token", refreshToken)
-	resp, err := http.PostForm(fmt.Sprintf("https://%s/services/oauth2/token", c.LoginUrl), params)
+	resp, err := postForm(ctx, fmt.Sprintf("https://%s/services/oauth2/token", c.LoginUrl), params)
 	if err != nil {
 		return err
 	}
@@ -165,14 +309,204 @@ func (c *Client) Refresh(refreshToken string) error {
 	if err != nil {
 		return err
 	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("soapforce: oauth refresh failed: %s: %s", tokenResponse["error"], tokenResponse["error_description"])
+	}
 
 	c.soapClient.SetServerUrl(fmt.Sprintf("%s/services/Soap/u/%s", tokenResponse["instance_url"], c.ApiVersion))
 	c.SetAccessToken(tokenResponse["access_token"])
 	return nil
 }
 
-func (c *Client) Logout() error {
-	_, err := c.soapClient.Logout(&Logout{})
+func (c *Client) Refresh(refreshToken string) error {
+	return c.RefreshContext(context.Background(), refreshToken)
+}
+
+// LoginWithJWTBearer implements Salesforce's OAuth 2.0 JWT Bearer Token Flow,
+// used for server-to-server integrations where no end user is present to
+// approve a password or authorization code grant. consumerKey identifies the
+// connected app, subject is the username the token is issued for, and
+// privateKey must correspond to the certificate uploaded to that connected
+// app.
+func (c *Client) LoginWithJWTBearerContext(ctx context.Context, consumerKey, subject string, privateKey *rsa.PrivateKey) error {
+	assertion, err := c.buildJWTAssertion(consumerKey, subject, privateKey)
+	if err != nil {
+		return err
+	}
+
+	params := url.Values{}
+	params.Add("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+	params.Add("assertion", assertion)
+	resp, err := postForm(ctx, fmt.Sprintf("https://%s/services/oauth2/token", c.LoginUrl), params)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	tokenResponse := map[string]string{}
+	err = json.Unmarshal(b, &tokenResponse)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("soapforce: jwt bearer login failed: %s: %s", tokenResponse["error"], tokenResponse["error_description"])
+	}
+
+	c.soapClient.SetServerUrl(fmt.Sprintf("%s/services/Soap/u/%s", tokenResponse["instance_url"], c.ApiVersion))
+	c.ServerUrl = tokenResponse["instance_url"]
+	c.SetAccessToken(tokenResponse["access_token"])
+	return nil
+}
+
+func (c *Client) LoginWithJWTBearer(consumerKey, subject string, privateKey *rsa.PrivateKey) error {
+	return c.LoginWithJWTBearerContext(context.Background(), consumerKey, subject, privateKey)
+}
+
+// buildJWTAssertion builds and RS256-signs the JWT assertion required by the
+// JWT Bearer flow: https://help.salesforce.com/s/articleView?id=sf.remoteaccess_oauth_jwt_flow.htm
+func (c *Client) buildJWTAssertion(consumerKey, subject string, privateKey *rsa.PrivateKey) (string, error) {
+	header := map[string]string{
+		"alg": "RS256",
+	}
+	claims := map[string]interface{}{
+		"iss": consumerKey,
+		"sub": subject,
+		"aud": fmt.Sprintf("https://%s", c.LoginUrl),
+		"exp": time.Now().Add(3 * time.Minute).Unix(),
+	}
+
+	headerJson, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJson, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJson) + "." + base64.RawURLEncoding.EncodeToString(claimsJson)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// RevokeToken invalidates an OAuth access or refresh token per RFC 7009 by
+// POSTing it to the revoke endpoint. Unlike Logout, it does not require an
+// active SOAP session and can be used to proactively invalidate a token the
+// caller no longer needs, e.g. on user sign-out.
+func (c *Client) RevokeTokenContext(ctx context.Context, token string) error {
+	params := url.Values{}
+	params.Add("token", token)
+	resp, err := postForm(ctx, fmt.Sprintf("https://%s/services/oauth2/revoke", c.LoginUrl), params)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("soapforce: token revocation failed: %s: %s", resp.Status, string(b))
+	}
+	return nil
+}
+
+func (c *Client) RevokeToken(token string) error {
+	return c.RevokeTokenContext(context.Background(), token)
+}
+
+// BuildAuthorizeURL generates a PKCE code verifier and returns the
+// Salesforce authorization URL the user should be redirected to for the
+// OAuth 2.0 Authorization Code + PKCE flow, along with the codeVerifier and
+// state the caller must retain and later pass to ExchangeCode. This flow is
+// the recommended replacement for LoginWithOAuth's password grant in orgs
+// where it has been disabled.
+func (c *Client) BuildAuthorizeURL(redirectURI string, scopes []string) (authURL, codeVerifier, state string, err error) {
+	codeVerifier, err = randomURLSafeString(32)
+	if err != nil {
+		return "", "", "", err
+	}
+	state, err = randomURLSafeString(16)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	codeChallenge := base64.RawURLEncoding.EncodeToString(challenge[:])
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", c.ClientID)
+	params.Set("redirect_uri", redirectURI)
+	params.Set("scope", strings.Join(scopes, " "))
+	params.Set("state", state)
+	params.Set("code_challenge", codeChallenge)
+	params.Set("code_challenge_method", "S256")
+
+	authURL = fmt.Sprintf("https://%s/services/oauth2/authorize?%s", c.LoginUrl, params.Encode())
+	return authURL, codeVerifier, state, nil
+}
+
+// randomURLSafeString returns a base64url-encoded random string built from
+// n bytes read from crypto/rand.
+func randomURLSafeString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// ExchangeCode completes the Authorization Code + PKCE flow: it exchanges
+// code, obtained after the user approved access at the authURL returned by
+// BuildAuthorizeURL, together with codeVerifier, for an access and refresh
+// token, and configures the SOAP endpoint from the resulting instance_url
+// the same way LoginWithOAuth does.
+func (c *Client) ExchangeCode(ctx context.Context, code, codeVerifier, redirectURI string) (accessToken, refreshToken string, err error) {
+	params := url.Values{}
+	params.Add("grant_type", "authorization_code")
+	params.Add("client_id", c.ClientID)
+	params.Add("client_secret", c.ClientSecret)
+	params.Add("code", code)
+	params.Add("code_verifier", codeVerifier)
+	params.Add("redirect_uri", redirectURI)
+	resp, err := postForm(ctx, fmt.Sprintf("https://%s/services/oauth2/token", c.LoginUrl), params)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", err
+	}
+	tokenResponse := map[string]string{}
+	if err = json.Unmarshal(b, &tokenResponse); err != nil {
+		return "", "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("soapforce: authorization code exchange failed: %s: %s", tokenResponse["error"], tokenResponse["error_description"])
+	}
+
+	c.soapClient.SetServerUrl(fmt.Sprintf("%s/services/Soap/u/%s", tokenResponse["instance_url"], c.ApiVersion))
+	c.ServerUrl = tokenResponse["instance_url"]
+	c.SetAccessToken(tokenResponse["access_token"])
+	return tokenResponse["access_token"], tokenResponse["refresh_token"], nil
+}
+
+// LogoutContext does not go through withSessionRefresh: if the session is
+// already invalid there is nothing left to log out of, and refreshing it
+// just to immediately destroy it again would be pointless.
+func (c *Client) LogoutContext(ctx context.Context) error {
+	err := c.callWithRetry(ctx, func() error {
+		_, err := c.soapClient.Logout(&Logout{})
+		return err
+	})
 	if err != nil {
 		return err
 	}
@@ -181,118 +515,294 @@ func (c *Client) Logout() error {
 	return nil
 }
 
-func (c *Client) DescribeSObject(s string) (*DescribeSObjectResult, error) {
+func (c *Client) Logout() error {
+	return c.LogoutContext(context.Background())
+}
+
+func (c *Client) DescribeSObjectContext(ctx context.Context, s string) (*DescribeSObjectResult, error) {
 	req := &DescribeSObject{
 		SObjectType: s,
 	}
-	res, err := c.soapClient.DescribeSObject(req)
+	var res *DescribeSObjectResponse
+	err := c.callWithRetry(ctx, func() error {
+		return c.withSessionRefresh(ctx, func() error {
+			var err error
+			res, err = c.soapClient.DescribeSObject(req)
+			return err
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
 	return res.Result, nil
 }
 
-func (c *Client) DescribeGlobal() (*DescribeGlobalResult, error) {
-	res, err := c.soapClient.DescribeGlobal(&DescribeGlobal{})
+func (c *Client) DescribeSObject(s string) (*DescribeSObjectResult, error) {
+	return c.DescribeSObjectContext(context.Background(), s)
+}
+
+func (c *Client) DescribeGlobalContext(ctx context.Context) (*DescribeGlobalResult, error) {
+	var res *DescribeGlobalResponse
+	err := c.callWithRetry(ctx, func() error {
+		return c.withSessionRefresh(ctx, func() error {
+			var err error
+			res, err = c.soapClient.DescribeGlobal(&DescribeGlobal{})
+			return err
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
 	return res.Result, nil
 }
 
-func (c *Client) DescribeLayout(s string, l string, ids []string) (*DescribeLayoutResultResult, error) {
+func (c *Client) DescribeGlobal() (*DescribeGlobalResult, error) {
+	return c.DescribeGlobalContext(context.Background())
+}
+
+func (c *Client) DescribeLayoutContext(ctx context.Context, s string, l string, ids []string) (*DescribeLayoutResultResult, error) {
 	req := &DescribeLayout{
 		SObjectType:   s,
 		LayoutName:    l,
 		RecordTypeIds: ids,
 	}
-	res, err := c.soapClient.DescribeLayout(req)
+	var res *DescribeLayoutResponse
+	err := c.callWithRetry(ctx, func() error {
+		return c.withSessionRefresh(ctx, func() error {
+			var err error
+			res, err = c.soapClient.DescribeLayout(req)
+			return err
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
 	return res.Result, nil
 }
 
-func (c *Client) Create(s []*SObject) ([]*SaveResult, error) {
+func (c *Client) DescribeLayout(s string, l string, ids []string) (*DescribeLayoutResultResult, error) {
+	return c.DescribeLayoutContext(context.Background(), s, l, ids)
+}
+
+func (c *Client) CreateContext(ctx context.Context, s []*SObject) ([]*SaveResult, error) {
+	results, err := c.createOnce(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	return c.retrySaveFailures(ctx, s, results, c.createOnce)
+}
+
+// createOnce performs one Create call without resubmitting individually
+// failed records; callWithRetry may still resend the whole call. It is the submit
+// function retrySaveFailures resubmits failed records through, so unlike
+// CreateContext it must never itself call retrySaveFailures.
+func (c *Client) createOnce(ctx context.Context, s []*SObject) ([]*SaveResult, error) {
 	req := &Create{
 		SObjects: s,
 	}
-	res, err := c.soapClient.Create(req)
+	var res *CreateResponse
+	err := c.callWithRetry(ctx, func() error {
+		return c.withSessionRefresh(ctx, func() error {
+			var err error
+			res, err = c.soapClient.Create(req)
+			return err
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
 	return res.Result, nil
 }
 
-func (c *Client) Update(s []*SObject) ([]*SaveResult, error) {
+func (c *Client) Create(s []*SObject) ([]*SaveResult, error) {
+	return c.CreateContext(context.Background(), s)
+}
+
+func (c *Client) UpdateContext(ctx context.Context, s []*SObject) ([]*SaveResult, error) {
+	results, err := c.updateOnce(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	return c.retrySaveFailures(ctx, s, results, c.updateOnce)
+}
+
+// updateOnce performs one Update call without resubmitting individually
+// failed records; callWithRetry may still resend the whole call. It is the submit
+// function retrySaveFailures resubmits failed records through, so unlike
+// UpdateContext it must never itself call retrySaveFailures.
+func (c *Client) updateOnce(ctx context.Context, s []*SObject) ([]*SaveResult, error) {
 	req := &Update{
 		SObjects: s,
 	}
-	res, err := c.soapClient.Update(req)
+	var res *UpdateResponse
+	err := c.callWithRetry(ctx, func() error {
+		return c.withSessionRefresh(ctx, func() error {
+			var err error
+			res, err = c.soapClient.Update(req)
+			return err
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
 	return res.Result, nil
 }
 
-func (c *Client) Upsert(s []*SObject, key string) ([]*UpsertResult, error) {
+func (c *Client) Update(s []*SObject) ([]*SaveResult, error) {
+	return c.UpdateContext(context.Background(), s)
+}
+
+func (c *Client) UpsertContext(ctx context.Context, s []*SObject, key string) ([]*UpsertResult, error) {
+	results, err := c.upsertOnce(ctx, s, key)
+	if err != nil {
+		return nil, err
+	}
+	return c.retryUpsertFailures(ctx, s, results, func(ctx context.Context, objs []*SObject) ([]*UpsertResult, error) {
+		return c.upsertOnce(ctx, objs, key)
+	})
+}
+
+// upsertOnce performs one Upsert call without resubmitting individually
+// failed records; callWithRetry may still resend the whole call. It is the submit
+// function retryUpsertFailures resubmits failed records through, so unlike
+// UpsertContext it must never itself call retryUpsertFailures.
+func (c *Client) upsertOnce(ctx context.Context, s []*SObject, key string) ([]*UpsertResult, error) {
 	req := &Upsert{
 		SObjects:            s,
 		ExternalIDFieldName: key,
 	}
-	res, err := c.soapClient.Upsert(req)
+	var res *UpsertResponse
+	err := c.callWithRetry(ctx, func() error {
+		return c.withSessionRefresh(ctx, func() error {
+			var err error
+			res, err = c.soapClient.Upsert(req)
+			return err
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
 	return res.Result, nil
 }
 
-func (c *Client) Merge(mergeReq []*MergeRequest) ([]*MergeResult, error) {
+func (c *Client) Upsert(s []*SObject, key string) ([]*UpsertResult, error) {
+	return c.UpsertContext(context.Background(), s, key)
+}
+
+func (c *Client) MergeContext(ctx context.Context, mergeReq []*MergeRequest) ([]*MergeResult, error) {
+	results, err := c.mergeOnce(ctx, mergeReq)
+	if err != nil {
+		return nil, err
+	}
+	return c.retryMergeFailures(ctx, mergeReq, results, c.mergeOnce)
+}
+
+// mergeOnce performs one Merge call without resubmitting individually
+// failed requests; callWithRetry may still resend the whole call. It is the submit
+// function retryMergeFailures resubmits failed records through, so unlike
+// MergeContext it must never itself call retryMergeFailures.
+func (c *Client) mergeOnce(ctx context.Context, mergeReq []*MergeRequest) ([]*MergeResult, error) {
 	req := &Merge{
 		Request: mergeReq,
 	}
-	res, err := c.soapClient.Merge(req)
+	var res *MergeResponse
+	err := c.callWithRetry(ctx, func() error {
+		return c.withSessionRefresh(ctx, func() error {
+			var err error
+			res, err = c.soapClient.Merge(req)
+			return err
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
 	return res.Result, nil
 }
 
-func (c *Client) Delete(ids []string) ([]*DeleteResult, error) {
+func (c *Client) Merge(mergeReq []*MergeRequest) ([]*MergeResult, error) {
+	return c.MergeContext(context.Background(), mergeReq)
+}
+
+func (c *Client) DeleteContext(ctx context.Context, ids []string) ([]*DeleteResult, error) {
+	results, err := c.deleteOnce(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	return c.retryDeleteFailures(ctx, ids, results, c.deleteOnce)
+}
+
+// deleteOnce performs one Delete call without resubmitting individually
+// failed records; callWithRetry may still resend the whole call. It is the submit
+// function retryDeleteFailures resubmits failed records through, so unlike
+// DeleteContext it must never itself call retryDeleteFailures.
+func (c *Client) deleteOnce(ctx context.Context, ids []string) ([]*DeleteResult, error) {
 	req := &Delete{
 		Ids: ids,
 	}
-	res, err := c.soapClient.Delete(req)
+	var res *DeleteResponse
+	err := c.callWithRetry(ctx, func() error {
+		return c.withSessionRefresh(ctx, func() error {
+			var err error
+			res, err = c.soapClient.Delete(req)
+			return err
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
 	return res.Result, nil
 }
 
-func (c *Client) Undelete(ids []string) ([]*UndeleteResult, error) {
+func (c *Client) Delete(ids []string) ([]*DeleteResult, error) {
+	return c.DeleteContext(context.Background(), ids)
+}
+
+func (c *Client) UndeleteContext(ctx context.Context, ids []string) ([]*UndeleteResult, error) {
 	req := &Undelete{
 		Ids: ids,
 	}
-	res, err := c.soapClient.Undelete(req)
+	var res *UndeleteResponse
+	err := c.callWithRetry(ctx, func() error {
+		return c.withSessionRefresh(ctx, func() error {
+			var err error
+			res, err = c.soapClient.Undelete(req)
+			return err
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
 	return res.Result, nil
 }
 
-func (c *Client) Retrieve(s string, ids []string, fieldList string) ([]*SObject, error) {
+func (c *Client) Undelete(ids []string) ([]*UndeleteResult, error) {
+	return c.UndeleteContext(context.Background(), ids)
+}
+
+func (c *Client) RetrieveContext(ctx context.Context, s string, ids []string, fieldList string) ([]*SObject, error) {
 	req := &Retrieve{
 		SObjectType: s,
 		Ids:         ids,
 		FieldList:   fieldList,
 	}
-	res, err := c.soapClient.Retrieve(req)
+	var res *RetrieveResponse
+	err := c.callWithRetry(ctx, func() error {
+		return c.withSessionRefresh(ctx, func() error {
+			var err error
+			res, err = c.soapClient.Retrieve(req)
+			return err
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
 	return res.Result, nil
 }
 
+func (c *Client) Retrieve(s string, ids []string, fieldList string) ([]*SObject, error) {
+	return c.RetrieveContext(context.Background(), s, ids, fieldList)
+}
+
 func (c *Client) SetBatchSize(size int) {
 	c.BatchSize = int32(size)
 	c.setHeaders()
@@ -323,166 +833,331 @@ func (c *Client) setHeaders() {
 	c.soapClient.SetHeader(headers)
 }
 
-func (c *Client) Query(q string) (*QueryResult, error) {
+func (c *Client) QueryContext(ctx context.Context, q string) (*QueryResult, error) {
 	req := &Query{
 		QueryString: q,
 	}
-	res, err := c.soapClient.Query(req)
+	var res *QueryResponse
+	err := c.callWithRetry(ctx, func() error {
+		return c.withSessionRefresh(ctx, func() error {
+			var err error
+			res, err = c.soapClient.Query(req)
+			return err
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
 	return res.Result, nil
 }
 
-func (c *Client) QueryAll(q string) (*QueryResult, error) {
+func (c *Client) Query(q string) (*QueryResult, error) {
+	return c.QueryContext(context.Background(), q)
+}
+
+func (c *Client) QueryAllContext(ctx context.Context, q string) (*QueryResult, error) {
 	req := &QueryAll{
 		QueryString: q,
 	}
-	res, err := c.soapClient.QueryAll(req)
+	var res *QueryAllResponse
+	err := c.callWithRetry(ctx, func() error {
+		return c.withSessionRefresh(ctx, func() error {
+			var err error
+			res, err = c.soapClient.QueryAll(req)
+			return err
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
 	return res.Result, nil
 }
 
-func (c *Client) QueryMore(ql string) (*QueryResult, error) {
+func (c *Client) QueryAll(q string) (*QueryResult, error) {
+	return c.QueryAllContext(context.Background(), q)
+}
+
+func (c *Client) QueryMoreContext(ctx context.Context, ql string) (*QueryResult, error) {
 	req := &QueryMore{
 		QueryLocator: ql,
 	}
-	res, err := c.soapClient.QueryMore(req)
+	var res *QueryMoreResponse
+	err := c.callWithRetry(ctx, func() error {
+		return c.withSessionRefresh(ctx, func() error {
+			var err error
+			res, err = c.soapClient.QueryMore(req)
+			return err
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
 	return res.Result, nil
 }
 
-func (c *Client) Search(s string) (*SearchResult, error) {
+func (c *Client) QueryMore(ql string) (*QueryResult, error) {
+	return c.QueryMoreContext(context.Background(), ql)
+}
+
+func (c *Client) SearchContext(ctx context.Context, s string) (*SearchResult, error) {
 	req := &Search{
 		SearchString: s,
 	}
-	res, err := c.soapClient.Search(req)
+	var res *SearchResponse
+	err := c.callWithRetry(ctx, func() error {
+		return c.withSessionRefresh(ctx, func() error {
+			var err error
+			res, err = c.soapClient.Search(req)
+			return err
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
 	return res.Result, nil
 }
 
-func (c *Client) SetPassword(uid string, password string) (*SetPasswordResult, error) {
+func (c *Client) Search(s string) (*SearchResult, error) {
+	return c.SearchContext(context.Background(), s)
+}
+
+func (c *Client) SetPasswordContext(ctx context.Context, uid string, password string) (*SetPasswordResult, error) {
 	req := &SetPassword{
 		UserId:   uid,
 		Password: password,
 	}
-	res, err := c.soapClient.SetPassword(req)
+	var res *SetPasswordResponse
+	err := c.callWithRetry(ctx, func() error {
+		return c.withSessionRefresh(ctx, func() error {
+			var err error
+			res, err = c.soapClient.SetPassword(req)
+			return err
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
 	return res.Result, nil
 }
 
-func (c *Client) ResetPassword(uid string) (*ResetPasswordResult, error) {
+func (c *Client) SetPassword(uid string, password string) (*SetPasswordResult, error) {
+	return c.SetPasswordContext(context.Background(), uid, password)
+}
+
+func (c *Client) ResetPasswordContext(ctx context.Context, uid string) (*ResetPasswordResult, error) {
 	req := &ResetPassword{
 		UserId: uid,
 	}
-	res, err := c.soapClient.ResetPassword(req)
+	var res *ResetPasswordResponse
+	err := c.callWithRetry(ctx, func() error {
+		return c.withSessionRefresh(ctx, func() error {
+			var err error
+			res, err = c.soapClient.ResetPassword(req)
+			return err
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
 	return res.Result, nil
 }
 
-func (c *Client) GetUserInfo() (*GetUserInfoResult, error) {
-	res, err := c.soapClient.GetUserInfo(&GetUserInfo{})
+func (c *Client) ResetPassword(uid string) (*ResetPasswordResult, error) {
+	return c.ResetPasswordContext(context.Background(), uid)
+}
+
+func (c *Client) GetUserInfoContext(ctx context.Context) (*GetUserInfoResult, error) {
+	var res *GetUserInfoResponse
+	err := c.callWithRetry(ctx, func() error {
+		return c.withSessionRefresh(ctx, func() error {
+			var err error
+			res, err = c.soapClient.GetUserInfo(&GetUserInfo{})
+			return err
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
 	return res.Result, nil
 }
 
-func (c *Client) SendEmailMessage(ids string) (*SendEmailResult, error) {
+func (c *Client) GetUserInfo() (*GetUserInfoResult, error) {
+	return c.GetUserInfoContext(context.Background())
+}
+
+func (c *Client) SendEmailMessageContext(ctx context.Context, ids string) (*SendEmailResult, error) {
 	req := &SendEmailMessage{
 		Ids: ids,
 	}
-	res, err := c.soapClient.SendEmailMessage(req)
+	var res *SendEmailMessageResponse
+	err := c.callWithRetry(ctx, func() error {
+		return c.withSessionRefresh(ctx, func() error {
+			var err error
+			res, err = c.soapClient.SendEmailMessage(req)
+			return err
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
 	return res.Result, nil
 }
 
-func (c *Client) CompileAndTest(r *CompileAndTestRequest) (*CompileAndTestResult, error) {
+func (c *Client) SendEmailMessage(ids string) (*SendEmailResult, error) {
+	return c.SendEmailMessageContext(context.Background(), ids)
+}
+
+func (c *Client) CompileAndTestContext(ctx context.Context, r *CompileAndTestRequest) (*CompileAndTestResult, error) {
 	req := &CompileAndTest{
 		CompileAndTestRequest: r,
 	}
-	res, err := c.soapClient.CompileAndTest(req)
+	var res *CompileAndTestResponse
+	err := c.callWithRetry(ctx, func() error {
+		return c.withSessionRefresh(ctx, func() error {
+			var err error
+			res, err = c.soapClient.CompileAndTest(req)
+			return err
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
 	return res.Result, nil
 }
 
-func (c *Client) CompileClasses(scripts []string) ([]*CompileClassResult, error) {
+func (c *Client) CompileAndTest(r *CompileAndTestRequest) (*CompileAndTestResult, error) {
+	return c.CompileAndTestContext(context.Background(), r)
+}
+
+func (c *Client) CompileClassesContext(ctx context.Context, scripts []string) ([]*CompileClassResult, error) {
 	req := &CompileClasses{
 		Scripts: scripts,
 	}
-	res, err := c.soapClient.CompileClasses(req)
+	var res *CompileClassesResponse
+	err := c.callWithRetry(ctx, func() error {
+		return c.withSessionRefresh(ctx, func() error {
+			var err error
+			res, err = c.soapClient.CompileClasses(req)
+			return err
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
 	return res.Result, nil
 }
 
-func (c *Client) CompileTriggers(scripts []string) ([]*CompileTriggerResult, error) {
+func (c *Client) CompileClasses(scripts []string) ([]*CompileClassResult, error) {
+	return c.CompileClassesContext(context.Background(), scripts)
+}
+
+func (c *Client) CompileTriggersContext(ctx context.Context, scripts []string) ([]*CompileTriggerResult, error) {
 	req := &CompileTriggers{
 		Scripts: scripts,
 	}
-	res, err := c.soapClient.CompileTriggers(req)
+	var res *CompileTriggersResponse
+	err := c.callWithRetry(ctx, func() error {
+		return c.withSessionRefresh(ctx, func() error {
+			var err error
+			res, err = c.soapClient.CompileTriggers(req)
+			return err
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
 	return res.Result, nil
 }
 
-func (c *Client) ExecuteAnonymous(code string) (*ExecuteAnonymousResult, error) {
+func (c *Client) CompileTriggers(scripts []string) ([]*CompileTriggerResult, error) {
+	return c.CompileTriggersContext(context.Background(), scripts)
+}
+
+func (c *Client) ExecuteAnonymousContext(ctx context.Context, code string) (*ExecuteAnonymousResult, error) {
 	req := &ExecuteAnonymous{
 		String: code,
 	}
-	res, err := c.soapClient.ExecuteAnonymous(req)
+	var res *ExecuteAnonymousResponse
+	err := c.callWithRetry(ctx, func() error {
+		return c.withSessionRefresh(ctx, func() error {
+			var err error
+			res, err = c.soapClient.ExecuteAnonymous(req)
+			return err
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
 	return res.Result, nil
 }
 
-func (c *Client) RunTests(r *RunTestsRequest) (*RunTestsResult, error) {
+func (c *Client) ExecuteAnonymous(code string) (*ExecuteAnonymousResult, error) {
+	return c.ExecuteAnonymousContext(context.Background(), code)
+}
+
+func (c *Client) RunTestsContext(ctx context.Context, r *RunTestsRequest) (*RunTestsResult, error) {
 	req := &RunTests{
 		RunTestsRequest: r,
 	}
-	res, err := c.soapClient.RunTests(req)
+	var res *RunTestsResponse
+	err := c.callWithRetry(ctx, func() error {
+		return c.withSessionRefresh(ctx, func() error {
+			var err error
+			res, err = c.soapClient.RunTests(req)
+			return err
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
 	return res.Result, nil
 }
 
-func (c *Client) WsdlToApex(req *WsdlToApex) (*WsdlToApexResult, error) {
-	res, err := c.soapClient.WsdlToApex(req)
+func (c *Client) RunTests(r *RunTestsRequest) (*RunTestsResult, error) {
+	return c.RunTestsContext(context.Background(), r)
+}
+
+func (c *Client) WsdlToApexContext(ctx context.Context, req *WsdlToApex) (*WsdlToApexResult, error) {
+	var res *WsdlToApexResponse
+	err := c.callWithRetry(ctx, func() error {
+		return c.withSessionRefresh(ctx, func() error {
+			var err error
+			res, err = c.soapClient.WsdlToApex(req)
+			return err
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
 	return res.Result, nil
 }
 
-func (c *Client) SendEmail(m *Email) (*SendEmailResult, error) {
+func (c *Client) WsdlToApex(req *WsdlToApex) (*WsdlToApexResult, error) {
+	return c.WsdlToApexContext(context.Background(), req)
+}
+
+func (c *Client) SendEmailContext(ctx context.Context, m *Email) (*SendEmailResult, error) {
 	req := &SendEmail{
 		Messages: m,
 	}
-	res, err := c.soapClient.SendEmail(req)
+	var res *SendEmailResponse
+	err := c.callWithRetry(ctx, func() error {
+		return c.withSessionRefresh(ctx, func() error {
+			var err error
+			res, err = c.soapClient.SendEmail(req)
+			return err
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
 	return res.Result, nil
 }
 
+func (c *Client) SendEmail(m *Email) (*SendEmailResult, error) {
+	return c.SendEmailContext(context.Background(), m)
+}
+
 func (c *Client) GetInfo() *LimitInfoHeader {
 	return c.soapClient.GetInfo()
 }
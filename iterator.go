@@ -0,0 +1,104 @@
+package soapforce
+
+import "context"
+
+// QueryIterator streams the records of a SOQL query, transparently paging
+// through QueryMore as the local buffer is drained. It lets callers walk
+// result sets that are too large to hold in memory all at once, instead of
+// manually looping on QueryMore(result.QueryLocator) until Done.
+type QueryIterator struct {
+	// more fetches the next batch of records for locator. It is
+	// c.QueryMoreContext, bound ahead of time so Next doesn't need to
+	// carry a *Client around (and so tests can fake it out).
+	more func(ctx context.Context, locator string) (*QueryResult, error)
+	ctx  context.Context
+
+	records []*SObject
+	index   int
+	locator string
+	done    bool
+
+	cur *SObject
+	err error
+}
+
+// QueryIterator runs soql and returns a QueryIterator over its results,
+// fetching subsequent batches via QueryMore only once the local buffer is
+// drained. The batch size fetched per round trip follows BatchSize, the
+// same header used by Query/QueryMore.
+func (c *Client) QueryIterator(ctx context.Context, soql string) (*QueryIterator, error) {
+	return c.newQueryIterator(ctx, soql, false)
+}
+
+// QueryAllIterator is like QueryIterator but also walks archived and
+// deleted records, matching the semantics of QueryAll.
+func (c *Client) QueryAllIterator(ctx context.Context, soql string) (*QueryIterator, error) {
+	return c.newQueryIterator(ctx, soql, true)
+}
+
+func (c *Client) newQueryIterator(ctx context.Context, soql string, queryAll bool) (*QueryIterator, error) {
+	it := &QueryIterator{
+		more: c.QueryMoreContext,
+		ctx:  ctx,
+	}
+
+	var res *QueryResult
+	var err error
+	if queryAll {
+		res, err = c.QueryAllContext(ctx, soql)
+	} else {
+		res, err = c.QueryContext(ctx, soql)
+	}
+	if err != nil {
+		return nil, err
+	}
+	it.applyResult(res)
+	return it, nil
+}
+
+func (it *QueryIterator) applyResult(res *QueryResult) {
+	it.records = res.Records
+	it.index = 0
+	it.locator = res.QueryLocator
+	it.done = res.Done
+}
+
+// Next advances the iterator to the next record, fetching the next batch via
+// QueryMore when the local buffer is drained. It returns false once there
+// are no more records or an error occurred; use Err to tell the two apart.
+func (it *QueryIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	for it.index >= len(it.records) {
+		if it.done {
+			return false
+		}
+		res, err := it.more(it.ctx, it.locator)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.applyResult(res)
+	}
+	it.cur = it.records[it.index]
+	it.index++
+	return true
+}
+
+// Record returns the record the iterator most recently advanced to via
+// Next.
+func (it *QueryIterator) Record() *SObject {
+	return it.cur
+}
+
+// Err returns the first error encountered while iterating, if any.
+func (it *QueryIterator) Err() error {
+	return it.err
+}
+
+// Close releases resources held by the iterator. It is a no-op today but
+// lets callers defer it without depending on the iterator's implementation.
+func (it *QueryIterator) Close() error {
+	return nil
+}
@@ -0,0 +1,91 @@
+package soapforce
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func newRecords(n int) []*SObject {
+	objs := make([]*SObject, n)
+	for i := range objs {
+		objs[i] = new(SObject)
+	}
+	return objs
+}
+
+func TestQueryIteratorPaginates(t *testing.T) {
+	batch1 := newRecords(2)
+	batch2 := newRecords(2)
+	batch3 := newRecords(1)
+	batches := map[string]*QueryResult{
+		"locator1": {Records: batch2, QueryLocator: "locator2", Done: false},
+		"locator2": {Records: batch3, QueryLocator: "", Done: true},
+	}
+	it := &QueryIterator{
+		ctx: context.Background(),
+		more: func(ctx context.Context, locator string) (*QueryResult, error) {
+			res, ok := batches[locator]
+			if !ok {
+				t.Fatalf("unexpected QueryMore(%q)", locator)
+			}
+			return res, nil
+		},
+	}
+	it.applyResult(&QueryResult{Records: batch1, QueryLocator: "locator1", Done: false})
+
+	want := append(append(append([]*SObject{}, batch1...), batch2...), batch3...)
+	var got []*SObject
+	for it.Next() {
+		got = append(got, it.Record())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Err() = %v, want nil", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d records, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("record %d = %p, want %p", i, got[i], want[i])
+		}
+	}
+}
+
+func TestQueryIteratorDoneOnFirstBatch(t *testing.T) {
+	batch := newRecords(1)
+	it := &QueryIterator{ctx: context.Background()}
+	it.applyResult(&QueryResult{Records: batch, Done: true})
+
+	if !it.Next() || it.Record() != batch[0] {
+		t.Fatalf("first Next() should return the only record")
+	}
+	if it.Next() {
+		t.Fatalf("Next() = true after the only record in a Done batch, want false")
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Err() = %v, want nil", err)
+	}
+}
+
+func TestQueryIteratorPropagatesQueryMoreError(t *testing.T) {
+	wantErr := errors.New("soapforce: boom")
+	batch := newRecords(1)
+	it := &QueryIterator{
+		ctx: context.Background(),
+		more: func(ctx context.Context, locator string) (*QueryResult, error) {
+			return nil, wantErr
+		},
+	}
+	it.applyResult(&QueryResult{Records: batch, QueryLocator: "locator1", Done: false})
+
+	if !it.Next() || it.Record() != batch[0] {
+		t.Fatalf("first Next() should return the only record")
+	}
+	if it.Next() {
+		t.Fatalf("Next() = true after QueryMore error, want false")
+	}
+	if err := it.Err(); err != wantErr {
+		t.Errorf("Err() = %v, want %v", err, wantErr)
+	}
+}
@@ -0,0 +1,79 @@
+package soapforce
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildJWTAssertion(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+
+	c := &Client{LoginUrl: "test.salesforce.com"}
+	before := time.Now()
+	assertion, err := c.buildJWTAssertion("consumerKey123", "user@example.com", privateKey)
+	if err != nil {
+		t.Fatalf("buildJWTAssertion() error = %v", err)
+	}
+
+	parts := strings.Split(assertion, ".")
+	if len(parts) != 3 {
+		t.Fatalf("buildJWTAssertion() = %d dot-separated parts, want 3", len(parts))
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		t.Fatalf("decoding header: %v", err)
+	}
+	var header map[string]string
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		t.Fatalf("unmarshaling header: %v", err)
+	}
+	if header["alg"] != "RS256" {
+		t.Errorf("header[alg] = %q, want %q", header["alg"], "RS256")
+	}
+
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatalf("decoding claims: %v", err)
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(claimsJSON, &claims); err != nil {
+		t.Fatalf("unmarshaling claims: %v", err)
+	}
+	if claims["iss"] != "consumerKey123" {
+		t.Errorf("claims[iss] = %v, want %v", claims["iss"], "consumerKey123")
+	}
+	if claims["sub"] != "user@example.com" {
+		t.Errorf("claims[sub] = %v, want %v", claims["sub"], "user@example.com")
+	}
+	if claims["aud"] != "https://test.salesforce.com" {
+		t.Errorf("claims[aud] = %v, want %v", claims["aud"], "https://test.salesforce.com")
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		t.Fatalf("claims[exp] = %T, want number", claims["exp"])
+	}
+	wantExp := before.Add(3 * time.Minute).Unix()
+	if d := exp - float64(wantExp); d < -5 || d > 5 {
+		t.Errorf("claims[exp] = %v, want within 5s of %v", exp, wantExp)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatalf("decoding signature: %v", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(&privateKey.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+		t.Errorf("signature does not verify: %v", err)
+	}
+}
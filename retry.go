@@ -0,0 +1,223 @@
+package soapforce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+)
+
+// DefaultMaxRetryAttempts, DefaultRetryBaseDelay and DefaultRetryMaxDelay are
+// the parameters used by NewDefaultRetryPolicy.
+const (
+	DefaultMaxRetryAttempts = 5
+	DefaultRetryBaseDelay   = 200 * time.Millisecond
+	DefaultRetryMaxDelay    = 10 * time.Second
+
+	// DefaultRetryLimitThreshold is the fraction of a daily API limit, in
+	// [0,1], below which DefaultRetryPolicy.Throttle starts slowing down
+	// calls.
+	DefaultRetryLimitThreshold = 0.1
+)
+
+// transientFaultCodes are the SOAP fault codes considered safe to retry:
+// they indicate contention or rate limiting rather than a request problem.
+var transientFaultCodes = []string{
+	"UNABLE_TO_LOCK_ROW",
+	"REQUEST_LIMIT_EXCEEDED",
+}
+
+// RetryPolicy governs how a Client reacts to Salesforce API usage limits
+// and transient failures. Install a custom policy with SetRetryPolicy, or
+// pass nil to disable throttling and retries entirely.
+type RetryPolicy interface {
+	// ShouldRetry reports whether the call that produced err, on the given
+	// attempt (the first attempt is 1), should be retried, and if so after
+	// how long.
+	ShouldRetry(attempt int, err error) (retry bool, delay time.Duration)
+
+	// ShouldRetryPartial reports whether a still-failing record from an
+	// otherwise successful DML batch (Create/Update/Upsert/Delete/Merge)
+	// should be resubmitted on the given attempt, and if so after how
+	// long. The caller has already confirmed the record's fault code is
+	// one of transientFaultCodes; ShouldRetryPartial is not passed an
+	// error because there is no per-call error to classify, only a
+	// decision of whether and how long to wait before trying again.
+	ShouldRetryPartial(attempt int) (retry bool, delay time.Duration)
+
+	// Throttle is consulted before every call with the most recently
+	// observed LimitInfoHeader, which may be nil if none has been seen
+	// yet. It may block, honoring ctx, to slow the client down as the
+	// org's API limits run low.
+	Throttle(ctx context.Context, info *LimitInfoHeader)
+}
+
+// DefaultRetryPolicy is the RetryPolicy installed on every new Client. It
+// retries transient SOAP faults (UNABLE_TO_LOCK_ROW, REQUEST_LIMIT_EXCEEDED)
+// and temporary network errors with exponential backoff and jitter until
+// MaxAttempts attempts in all have been made, and sleeps proportionally longer before the next call
+// as the remaining daily API calls reported by LimitInfoHeader drop below
+// LimitThreshold.
+type DefaultRetryPolicy struct {
+	MaxAttempts    int
+	BaseDelay      time.Duration
+	MaxDelay       time.Duration
+	LimitThreshold float64
+}
+
+// NewDefaultRetryPolicy returns a DefaultRetryPolicy configured with the
+// package's default attempt count, backoff bounds and limit threshold.
+func NewDefaultRetryPolicy() *DefaultRetryPolicy {
+	return &DefaultRetryPolicy{
+		MaxAttempts:    DefaultMaxRetryAttempts,
+		BaseDelay:      DefaultRetryBaseDelay,
+		MaxDelay:       DefaultRetryMaxDelay,
+		LimitThreshold: DefaultRetryLimitThreshold,
+	}
+}
+
+func (p *DefaultRetryPolicy) ShouldRetry(attempt int, err error) (bool, time.Duration) {
+	if err == nil || !isTransientError(err) {
+		return false, 0
+	}
+	return p.ShouldRetryPartial(attempt)
+}
+
+func (p *DefaultRetryPolicy) ShouldRetryPartial(attempt int) (bool, time.Duration) {
+	if attempt >= p.MaxAttempts {
+		return false, 0
+	}
+	delay := p.BaseDelay * time.Duration(math.Pow(2, float64(attempt-1)))
+	if delay > p.MaxDelay || delay <= 0 {
+		delay = p.MaxDelay
+	}
+	return true, delay/2 + time.Duration(rand.Int63n(int64(delay)/2+1))
+}
+
+func (p *DefaultRetryPolicy) Throttle(ctx context.Context, info *LimitInfoHeader) {
+	if info == nil {
+		return
+	}
+	for _, limit := range info.LimitInfo {
+		if limit == nil || limit.Type != "API REQUESTS" || limit.Limit <= 0 {
+			continue
+		}
+		remaining := float64(limit.Limit-limit.Current) / float64(limit.Limit)
+		if remaining >= p.LimitThreshold {
+			return
+		}
+		sleepCtx(ctx, time.Duration((p.LimitThreshold-remaining)/p.LimitThreshold*float64(p.MaxDelay)))
+		return
+	}
+}
+
+// isTransientError reports whether err is a SOAP fault known to be safe to
+// retry, or a network error the net package marked temporary.
+func isTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, code := range transientFaultCodes {
+		if strings.Contains(err.Error(), code) {
+			return true
+		}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return netErr.Temporary()
+	}
+	return false
+}
+
+// hasTransientError reports whether errs contains an Error whose
+// StatusCode matches one of transientFaultCodes.
+func hasTransientError(errs []*Error) bool {
+	for _, e := range errs {
+		if e == nil {
+			continue
+		}
+		code := fmt.Sprintf("%v", e.StatusCode)
+		for _, transient := range transientFaultCodes {
+			if code == transient {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// retryPartialFailures is the shared control flow behind retrySaveFailures,
+// retryUpsertFailures, retryDeleteFailures, and retryMergeFailures: it
+// repeatedly resubmits, via submit, only the batch items whose
+// corresponding result still fails according to failed, merging the
+// retried results back into their original positions. items and results
+// must be the same length and in the same order as returned by submit.
+func retryPartialFailures[I, R any](c *Client, ctx context.Context, items []I, results []R, failed func(R) bool, submit func(context.Context, []I) ([]R, error)) ([]R, error) {
+	for attempt := 1; c.retryPolicy != nil; attempt++ {
+		var failedIdx []int
+		for i, r := range results {
+			if failed(r) {
+				failedIdx = append(failedIdx, i)
+			}
+		}
+		if len(failedIdx) == 0 {
+			return results, nil
+		}
+		if retry, delay := c.retryPolicy.ShouldRetryPartial(attempt); !retry {
+			return results, nil
+		} else if sleepErr := sleepCtx(ctx, delay); sleepErr != nil {
+			return results, sleepErr
+		}
+
+		retryItems := make([]I, len(failedIdx))
+		for j, idx := range failedIdx {
+			retryItems[j] = items[idx]
+		}
+		retryResults, err := submit(ctx, retryItems)
+		if err != nil {
+			return results, err
+		}
+		for j, idx := range failedIdx {
+			results[idx] = retryResults[j]
+		}
+	}
+	return results, nil
+}
+
+// retrySaveFailures resubmits, via submit, only the SObjects whose
+// corresponding SaveResult failed with a transient error, merging the
+// retried results back into their original positions. objs and results
+// must be the same length and in the same order as returned by submit.
+func (c *Client) retrySaveFailures(ctx context.Context, objs []*SObject, results []*SaveResult, submit func(context.Context, []*SObject) ([]*SaveResult, error)) ([]*SaveResult, error) {
+	return retryPartialFailures(c, ctx, objs, results, func(r *SaveResult) bool {
+		return r != nil && !r.Success && hasTransientError(r.Errors)
+	}, submit)
+}
+
+// retryUpsertFailures is retrySaveFailures for Upsert, whose results carry
+// an extra Created flag alongside the same Success/Errors fields.
+func (c *Client) retryUpsertFailures(ctx context.Context, objs []*SObject, results []*UpsertResult, submit func(context.Context, []*SObject) ([]*UpsertResult, error)) ([]*UpsertResult, error) {
+	return retryPartialFailures(c, ctx, objs, results, func(r *UpsertResult) bool {
+		return r != nil && !r.Success && hasTransientError(r.Errors)
+	}, submit)
+}
+
+// retryDeleteFailures is retrySaveFailures for Delete, which keys its batch
+// by Id rather than by SObject.
+func (c *Client) retryDeleteFailures(ctx context.Context, ids []string, results []*DeleteResult, submit func(context.Context, []string) ([]*DeleteResult, error)) ([]*DeleteResult, error) {
+	return retryPartialFailures(c, ctx, ids, results, func(r *DeleteResult) bool {
+		return r != nil && !r.Success && hasTransientError(r.Errors)
+	}, submit)
+}
+
+// retryMergeFailures is retrySaveFailures for Merge, whose batch is keyed
+// by MergeRequest rather than by SObject.
+func (c *Client) retryMergeFailures(ctx context.Context, reqs []*MergeRequest, results []*MergeResult, submit func(context.Context, []*MergeRequest) ([]*MergeResult, error)) ([]*MergeResult, error) {
+	return retryPartialFailures(c, ctx, reqs, results, func(r *MergeResult) bool {
+		return r != nil && !r.Success && hasTransientError(r.Errors)
+	}, submit)
+}
@@ -0,0 +1,105 @@
+package soapforce
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDefaultRetryPolicyShouldRetry(t *testing.T) {
+	p := &DefaultRetryPolicy{MaxAttempts: 3, BaseDelay: 10 * time.Millisecond, MaxDelay: 100 * time.Millisecond}
+
+	if retry, _ := p.ShouldRetry(1, nil); retry {
+		t.Error("ShouldRetry(1, nil) = true, want false")
+	}
+	if retry, _ := p.ShouldRetry(1, errors.New("INVALID_FIELD")); retry {
+		t.Error("ShouldRetry(1, non-transient error) = true, want false")
+	}
+
+	transient := errors.New("soapforce: fault REQUEST_LIMIT_EXCEEDED")
+	for attempt := 1; attempt < p.MaxAttempts; attempt++ {
+		retry, delay := p.ShouldRetry(attempt, transient)
+		if !retry {
+			t.Errorf("ShouldRetry(%d, transient) = false, want true", attempt)
+		}
+		if delay <= 0 || delay > p.MaxDelay {
+			t.Errorf("ShouldRetry(%d, transient) delay = %v, want in (0, %v]", attempt, delay, p.MaxDelay)
+		}
+	}
+	if retry, delay := p.ShouldRetry(p.MaxAttempts, transient); retry || delay != 0 {
+		t.Errorf("ShouldRetry(MaxAttempts, transient) = (%v, %v), want (false, 0)", retry, delay)
+	}
+}
+
+func TestDefaultRetryPolicyShouldRetryPartial(t *testing.T) {
+	p := &DefaultRetryPolicy{MaxAttempts: 3, BaseDelay: 10 * time.Millisecond, MaxDelay: 100 * time.Millisecond}
+
+	for attempt := 1; attempt < p.MaxAttempts; attempt++ {
+		retry, delay := p.ShouldRetryPartial(attempt)
+		if !retry {
+			t.Errorf("ShouldRetryPartial(%d) = false, want true", attempt)
+		}
+		if delay <= 0 || delay > p.MaxDelay {
+			t.Errorf("ShouldRetryPartial(%d) delay = %v, want in (0, %v]", attempt, delay, p.MaxDelay)
+		}
+	}
+	if retry, delay := p.ShouldRetryPartial(p.MaxAttempts); retry || delay != 0 {
+		t.Errorf("ShouldRetryPartial(MaxAttempts) = (%v, %v), want (false, 0)", retry, delay)
+	}
+}
+
+func TestDefaultRetryPolicyThrottle(t *testing.T) {
+	p := &DefaultRetryPolicy{MaxAttempts: 3, BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond, LimitThreshold: 0.1}
+
+	start := time.Now()
+	p.Throttle(context.Background(), nil)
+	if elapsed := time.Since(start); elapsed > 10*time.Millisecond {
+		t.Errorf("Throttle(nil) took %v, want near-instant", elapsed)
+	}
+
+	plenty := &LimitInfoHeader{LimitInfo: []*LimitInfo{{Type: "API REQUESTS", Limit: 1000, Current: 10}}}
+	start = time.Now()
+	p.Throttle(context.Background(), plenty)
+	if elapsed := time.Since(start); elapsed > 10*time.Millisecond {
+		t.Errorf("Throttle(plenty remaining) took %v, want near-instant", elapsed)
+	}
+
+	scarce := &LimitInfoHeader{LimitInfo: []*LimitInfo{{Type: "API REQUESTS", Limit: 1000, Current: 999}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start = time.Now()
+	p.Throttle(ctx, scarce)
+	if elapsed := time.Since(start); elapsed > 10*time.Millisecond {
+		t.Errorf("Throttle(scarce remaining, cancelled ctx) took %v, want to return promptly on cancellation", elapsed)
+	}
+}
+
+func TestIsTransientError(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("UNABLE_TO_LOCK_ROW: record locked"), true},
+		{errors.New("REQUEST_LIMIT_EXCEEDED"), true},
+		{errors.New("INVALID_FIELD"), false},
+	}
+	for _, tc := range cases {
+		if got := isTransientError(tc.err); got != tc.want {
+			t.Errorf("isTransientError(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestHasTransientError(t *testing.T) {
+	if hasTransientError(nil) {
+		t.Error("hasTransientError(nil) = true, want false")
+	}
+	if hasTransientError([]*Error{{StatusCode: "INVALID_FIELD"}}) {
+		t.Error("hasTransientError(non-transient) = true, want false")
+	}
+	if !hasTransientError([]*Error{{StatusCode: "INVALID_FIELD"}, {StatusCode: "REQUEST_LIMIT_EXCEEDED"}}) {
+		t.Error("hasTransientError(mixed, one transient) = false, want true")
+	}
+}